@@ -0,0 +1,124 @@
+package main
+
+/* Per-peer policy for choosing which of the device's active binds (see
+ * activeBind in conn.go) to transmit a packet on, for multi-homed hosts
+ * running more than one bind at a time.
+ */
+
+import (
+	"errors"
+	"time"
+)
+
+type BindPolicy int
+
+const (
+	BindPolicyAuto          BindPolicy = iota // round-robin across the active binds
+	BindPolicyLowestLatency                   // the bind with the lowest measured handshake RTT
+	BindPolicyFixedSource                     // the bind matching the peer's configured allowed-source-ips=
+)
+
+/* SelectBind chooses which active bind to transmit the peer's next
+ * packet on. If the endpoint is already pinned to a bind that's still
+ * alive, that choice is reused; otherwise the peer's BindPolicy decides,
+ * and the result is pinned back onto the endpoint with SetBindID so
+ * later packets skip the decision until the pin is cleared (BindUpdate)
+ * or the pinned bind disappears (RebindInterface, or interface removal).
+ */
+func (device *Device) SelectBind(peer *Peer, endpoint Endpoint) (Bind, error) {
+
+	// Only the slice header needs the lock; see replaceBind's doc
+	// comment in conn.go for why activeBind entries are safe to read
+	// unlocked once published.
+	device.net.mutex.Lock()
+	binds := device.net.binds
+	device.net.mutex.Unlock()
+
+	if len(binds) == 0 {
+		return nil, errors.New("no active binds")
+	}
+
+	if b := findBindByID(binds, endpoint.BindID()); b != nil {
+		return b.bind, nil
+	}
+	// the pinned bind is gone (or nothing was pinned yet); fall through
+	// and pick a new one
+
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	chosen, nextRoundRobin := choosePolicyBind(binds, peer.bindPolicy, peer.allowedSourceIP, peer.bindRTT, peer.roundRobinIndex)
+	peer.roundRobinIndex = nextRoundRobin
+
+	endpoint.SetBindID(chosen.id)
+	return chosen.bind, nil
+}
+
+/* findBindByID returns the bind in binds with the given id, or nil if
+ * id is empty or none matches.
+ */
+func findBindByID(binds []*activeBind, id string) *activeBind {
+	if id == "" {
+		return nil
+	}
+	for _, b := range binds {
+		if b.id == id {
+			return b
+		}
+	}
+	return nil
+}
+
+/* choosePolicyBind applies policy over binds given a peer's current
+ * selection state, and returns the chosen bind along with the
+ * roundRobinIndex the peer should store for next time. It takes no
+ * *Device/*Peer so it can be unit-tested directly. binds must be
+ * non-empty.
+ */
+func choosePolicyBind(binds []*activeBind, policy BindPolicy, allowedSourceIP string, bindRTT map[string]time.Duration, roundRobinIndex int) (chosen *activeBind, nextRoundRobinIndex int) {
+	switch policy {
+	case BindPolicyFixedSource:
+		for _, b := range binds {
+			if b.addr == allowedSourceIP {
+				return b, roundRobinIndex
+			}
+		}
+	case BindPolicyLowestLatency:
+		var best time.Duration
+		for _, b := range binds {
+			rtt, ok := bindRTT[b.id]
+			if !ok {
+				continue
+			}
+			if chosen == nil || rtt < best {
+				chosen, best = b, rtt
+			}
+		}
+		if chosen != nil {
+			return chosen, roundRobinIndex
+		}
+	}
+
+	// BindPolicyAuto, or a more specific policy that found nothing to
+	// match: round-robin across the available binds.
+	return binds[roundRobinIndex%len(binds)], roundRobinIndex + 1
+}
+
+/* RecordHandshakeRTT feeds a freshly measured handshake RTT into the
+ * peer's BindPolicyLowestLatency bookkeeping and re-pins its endpoint,
+ * so a faster bind found mid-handshake is picked up immediately.
+ */
+func (device *Device) RecordHandshakeRTT(peer *Peer, bindID string, rtt time.Duration) {
+	peer.mutex.Lock()
+	if peer.bindRTT == nil {
+		peer.bindRTT = make(map[string]time.Duration)
+	}
+	peer.bindRTT[bindID] = rtt
+	endpoint := peer.endpoint
+	peer.mutex.Unlock()
+
+	if endpoint != nil {
+		endpoint.SetBindID("")
+		device.SelectBind(peer, endpoint)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func testBinds(ids ...string) []*activeBind {
+	binds := make([]*activeBind, len(ids))
+	for i, id := range ids {
+		binds[i] = &activeBind{id: id, addr: id}
+	}
+	return binds
+}
+
+func TestChoosePolicyBindRoundRobin(t *testing.T) {
+	binds := testBinds("a", "b", "c")
+
+	next := 0
+	for _, want := range []string{"a", "b", "c", "a"} {
+		var chosen *activeBind
+		chosen, next = choosePolicyBind(binds, BindPolicyAuto, "", nil, next)
+		if chosen.id != want {
+			t.Fatalf("got %s, want %s", chosen.id, want)
+		}
+	}
+}
+
+func TestChoosePolicyBindFixedSource(t *testing.T) {
+	binds := testBinds("a", "b", "c")
+
+	chosen, _ := choosePolicyBind(binds, BindPolicyFixedSource, "b", nil, 0)
+	if chosen.id != "b" {
+		t.Fatalf("got %s, want b", chosen.id)
+	}
+}
+
+func TestChoosePolicyBindFixedSourceFallsBackToRoundRobin(t *testing.T) {
+	binds := testBinds("a", "b", "c")
+
+	// no bind matches the configured source: falls back to round-robin
+	chosen, next := choosePolicyBind(binds, BindPolicyFixedSource, "does-not-exist", nil, 0)
+	if chosen.id != "a" || next != 1 {
+		t.Fatalf("got (%s, %d), want (a, 1)", chosen.id, next)
+	}
+}
+
+func TestChoosePolicyBindLowestLatency(t *testing.T) {
+	binds := testBinds("a", "b", "c")
+	rtt := map[string]time.Duration{
+		"a": 50 * time.Millisecond,
+		"b": 10 * time.Millisecond,
+		"c": 30 * time.Millisecond,
+	}
+
+	chosen, _ := choosePolicyBind(binds, BindPolicyLowestLatency, "", rtt, 0)
+	if chosen.id != "b" {
+		t.Fatalf("got %s, want b (lowest RTT)", chosen.id)
+	}
+}
+
+func TestChoosePolicyBindLowestLatencyFallsBackToRoundRobin(t *testing.T) {
+	binds := testBinds("a", "b", "c")
+
+	// no RTT measurements yet: falls back to round-robin
+	chosen, next := choosePolicyBind(binds, BindPolicyLowestLatency, "", nil, 2)
+	if chosen.id != "c" || next != 3 {
+		t.Fatalf("got (%s, %d), want (c, 3)", chosen.id, next)
+	}
+}
+
+func TestFindBindByID(t *testing.T) {
+	binds := testBinds("a", "b", "c")
+
+	if b := findBindByID(binds, "b"); b == nil || b.id != "b" {
+		t.Fatalf("expected to find bind \"b\"")
+	}
+	if b := findBindByID(binds, "missing"); b != nil {
+		t.Fatalf("expected no match for an unknown id")
+	}
+	if b := findBindByID(binds, ""); b != nil {
+		t.Fatalf("expected no match for an empty id")
+	}
+}
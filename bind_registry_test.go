@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestRegisterNetworkingRejectsDuplicateNames(t *testing.T) {
+	name := "test-registry-duplicate"
+	factory := func(cfg map[string]string) (Networking, error) { return nil, nil }
+
+	if err := RegisterNetworking(name, factory); err != nil {
+		t.Fatalf("first registration should succeed: %v", err)
+	}
+
+	if err := RegisterNetworking(name, factory); err == nil {
+		t.Fatalf("second registration under the same name should fail")
+	}
+}
+
+func TestNewNetworkingUnknownBackend(t *testing.T) {
+	if _, err := newNetworking("test-registry-does-not-exist", nil); err == nil {
+		t.Fatalf("expected an error for an unregistered backend name")
+	}
+}
+
+func TestNewNetworkingUsesRegisteredFactory(t *testing.T) {
+	name := "test-registry-roundtrip"
+	want := errorNetworking{}
+	factory := func(cfg map[string]string) (Networking, error) { return want, nil }
+
+	if err := RegisterNetworking(name, factory); err != nil {
+		t.Fatalf("registration should succeed: %v", err)
+	}
+
+	got, err := newNetworking(name, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != Networking(want) {
+		t.Fatalf("newNetworking did not return the value built by the registered factory")
+	}
+}
+
+// errorNetworking is a minimal Networking for exercising the registry
+// without a real bind implementation.
+type errorNetworking struct{}
+
+func (errorNetworking) CreateBind(port uint16) (Bind, uint16, error) { return nil, 0, nil }
+func (errorNetworking) CreateEndpoint(addr string) (Endpoint, error) { return nil, nil }
+func (errorNetworking) CreateBindOnAddress(addr string, port uint16) (Bind, uint16, error) {
+	return nil, 0, nil
+}
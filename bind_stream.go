@@ -0,0 +1,320 @@
+package main
+
+/* A Networking backend that tunnels WireGuard UDP datagrams over outer
+ * TCP connections (one dialled out, plus any accepted on a listener),
+ * each datagram prefixed with a 2-byte big-endian length, for networks
+ * that block or throttle outbound UDP.
+ * Only plain TCP framing is implemented; DTLS is itself a datagram
+ * protocol and can't wrap a TCP stream, so it would need its own
+ * backend. Selected via "network_backend=tcp-framed", with
+ * "network_backend_remote=" / "network_backend_listen=" to dial out /
+ * accept inbound connections.
+ */
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+)
+
+func init() {
+	if err := RegisterNetworking("tcp-framed", newStreamNetworking); err != nil {
+		log.Fatalln("tcp-framed:", err)
+	}
+}
+
+type streamNetworking struct {
+	remote string
+	listen string
+
+	mutex sync.Mutex
+	bound bool // true once the single outer connection/listener is in use
+}
+
+func newStreamNetworking(cfg map[string]string) (Networking, error) {
+	n := &streamNetworking{
+		remote: cfg["remote"],
+		listen: cfg["listen"],
+	}
+	if n.remote == "" && n.listen == "" {
+		return nil, errors.New("tcp-framed backend requires a \"remote\" or \"listen\" option")
+	}
+	return n, nil
+}
+
+func (n *streamNetworking) CreateBind(port uint16) (Bind, uint16, error) {
+	return n.CreateBindOnAddress("", port)
+}
+
+/* CreateBindOnAddress ignores addr: there is only one outer connection
+ * (or listener) for this backend, not one per local address. A second
+ * bind_address would just retry the same "remote"/"listen" address and
+ * fail with a confusing EADDRINUSE, so it's rejected explicitly instead.
+ */
+func (n *streamNetworking) CreateBindOnAddress(addr string, port uint16) (Bind, uint16, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.bound {
+		return nil, 0, errors.New("tcp-framed backend does not support more than one simultaneous bind; configure at most one bind_address")
+	}
+
+	// owner is deliberately left nil until setup succeeds: Close() on a
+	// failed partial setup below must not call back into n.release(),
+	// which would try to re-take n.mutex while this call still holds it.
+	bind := newStreamBind(nil)
+
+	if n.listen != "" {
+		ln, err := net.Listen("tcp", n.listen)
+		if err != nil {
+			return nil, 0, err
+		}
+		bind.listener = ln
+		if tcpAddr, ok := ln.Addr().(*net.TCPAddr); ok {
+			port = uint16(tcpAddr.Port)
+		}
+		go bind.acceptLoop()
+	}
+
+	if n.remote != "" {
+		conn, err := net.Dial("tcp", n.remote)
+		if err != nil {
+			bind.Close()
+			return nil, 0, err
+		}
+		bind.addConn(conn)
+		bind.mutex.Lock()
+		bind.defaultConn = conn
+		bind.mutex.Unlock()
+	}
+
+	bind.owner = n
+	n.bound = true
+	return bind, port, nil
+}
+
+// release lets a later CreateBindOnAddress reuse the single outer
+// connection slot once the current bind has been torn down.
+func (n *streamNetworking) release() {
+	n.mutex.Lock()
+	n.bound = false
+	n.mutex.Unlock()
+}
+
+func (n *streamNetworking) CreateEndpoint(addr string) (Endpoint, error) {
+	return &streamEndpoint{addr: addr}, nil
+}
+
+type streamFrame struct {
+	data []byte
+	end  *streamEndpoint
+}
+
+/* streamBind multiplexes every peer reachable through this backend over
+ * one or more outer stream connections, keyed by the remote address of
+ * the outer connection.
+ */
+type streamBind struct {
+	owner       *streamNetworking
+	mutex       sync.Mutex
+	listener    net.Listener
+	defaultConn net.Conn
+	byAddr      map[string]net.Conn
+	incoming    chan streamFrame
+	done        chan struct{}
+}
+
+func newStreamBind(owner *streamNetworking) *streamBind {
+	return &streamBind{
+		owner:    owner,
+		byAddr:   make(map[string]net.Conn),
+		incoming: make(chan streamFrame, 128),
+		done:     make(chan struct{}),
+	}
+}
+
+func (bind *streamBind) acceptLoop() {
+	for {
+		conn, err := bind.listener.Accept()
+		if err != nil {
+			return
+		}
+		bind.addConn(conn)
+	}
+}
+
+func (bind *streamBind) addConn(conn net.Conn) {
+	bind.mutex.Lock()
+	bind.byAddr[conn.RemoteAddr().String()] = conn
+	bind.mutex.Unlock()
+	go bind.readLoop(conn)
+}
+
+func (bind *streamBind) readLoop(conn net.Conn) {
+	defer func() {
+		bind.mutex.Lock()
+		delete(bind.byAddr, conn.RemoteAddr().String())
+		bind.mutex.Unlock()
+		conn.Close()
+	}()
+
+	end := &streamEndpoint{addr: conn.RemoteAddr().String(), conn: conn}
+	var length [2]byte
+
+	for {
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return
+		}
+		data := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+		select {
+		case bind.incoming <- streamFrame{data: data, end: end}:
+		case <-bind.done:
+			return
+		}
+	}
+}
+
+func (bind *streamBind) SetMark(value uint32) error {
+	// The fwmark applies to the outer TCP socket(s), which are opened
+	// with the standard library's net.Dial/net.Listen and so aren't
+	// reachable here; apply it out of band if the platform needs it.
+	return nil
+}
+
+func (bind *streamBind) receive(buff []byte) (int, Endpoint, error) {
+	select {
+	case frame, ok := <-bind.incoming:
+		if !ok {
+			return 0, nil, errors.New("tcp-framed bind closed")
+		}
+		return copy(buff, frame.data), frame.end, nil
+	case <-bind.done:
+		return 0, nil, errors.New("tcp-framed bind closed")
+	}
+}
+
+func (bind *streamBind) ReceiveIPv4(buff []byte) (int, Endpoint, error) {
+	return bind.receive(buff)
+}
+
+// The outer stream carries both address families indistinguishably, so
+// all frames surface through ReceiveIPv4; this simply blocks until the
+// bind is closed, mirroring how RoutineReceiveIncoming expects a
+// Receive call to behave when there's nothing of that family to report.
+func (bind *streamBind) ReceiveIPv6(buff []byte) (int, Endpoint, error) {
+	<-bind.done
+	return 0, nil, errors.New("tcp-framed bind closed")
+}
+
+func (bind *streamBind) Send(buff []byte, end Endpoint) error {
+	se, ok := end.(*streamEndpoint)
+	if !ok {
+		return errors.New("tcp-framed backend: invalid endpoint type")
+	}
+
+	conn := se.Conn()
+	if conn == nil {
+		bind.mutex.Lock()
+		conn = bind.defaultConn
+		bind.mutex.Unlock()
+	}
+	if conn == nil {
+		return errors.New("tcp-framed backend: no outer connection for endpoint " + se.DstToString())
+	}
+
+	frame := make([]byte, 2+len(buff))
+	binary.BigEndian.PutUint16(frame, uint16(len(buff)))
+	copy(frame[2:], buff)
+	_, err := conn.Write(frame)
+	return err
+}
+
+func (bind *streamBind) Close() error {
+	bind.mutex.Lock()
+	defer bind.mutex.Unlock()
+
+	select {
+	case <-bind.done:
+	default:
+		close(bind.done)
+	}
+
+	if bind.listener != nil {
+		bind.listener.Close()
+	}
+	for _, conn := range bind.byAddr {
+		conn.Close()
+	}
+	if bind.defaultConn != nil {
+		bind.defaultConn.Close()
+	}
+	if bind.owner != nil {
+		bind.owner.release()
+	}
+	return nil
+}
+
+/* streamEndpoint is a StreamEndpoint: replies are routed back over the
+ * outer connection the datagram was last seen on rather than addressed
+ * with sendto.
+ */
+type streamEndpoint struct {
+	mutex  sync.Mutex
+	addr   string
+	conn   net.Conn
+	bindID string
+}
+
+func (end *streamEndpoint) ClearSrc() {}
+
+func (end *streamEndpoint) SrcToString() string { return "" }
+
+func (end *streamEndpoint) DstToString() string {
+	end.mutex.Lock()
+	defer end.mutex.Unlock()
+	return end.addr
+}
+
+func (end *streamEndpoint) DstToBytes() []byte {
+	return []byte(end.DstToString())
+}
+
+func (end *streamEndpoint) DstIP() net.IP {
+	host, _, err := net.SplitHostPort(end.DstToString())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+func (end *streamEndpoint) SrcIP() net.IP { return nil }
+
+func (end *streamEndpoint) Conn() net.Conn {
+	end.mutex.Lock()
+	defer end.mutex.Unlock()
+	return end.conn
+}
+
+func (end *streamEndpoint) SetConn(conn net.Conn) {
+	end.mutex.Lock()
+	end.conn = conn
+	end.mutex.Unlock()
+}
+
+func (end *streamEndpoint) BindID() string {
+	end.mutex.Lock()
+	defer end.mutex.Unlock()
+	return end.bindID
+}
+
+func (end *streamEndpoint) SetBindID(id string) {
+	end.mutex.Lock()
+	end.bindID = id
+	end.mutex.Unlock()
+}
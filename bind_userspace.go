@@ -0,0 +1,192 @@
+package main
+
+/* A Networking backend built on net.PacketConn over an already-open
+ * file descriptor, for sandboxed or rootless environments where the
+ * WireGuard process itself can't call socket()/bind() but a privileged
+ * parent can and hands the fd down. Selected via
+ * "network_backend=userspace-fd", with the descriptor given as
+ * "network_backend_fd=<n>".
+ */
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+func init() {
+	if err := RegisterNetworking("userspace-fd", newUserspaceFDNetworking); err != nil {
+		log.Fatalln("userspace-fd:", err)
+	}
+}
+
+type userspaceFDNetworking struct {
+	fd int
+}
+
+func newUserspaceFDNetworking(cfg map[string]string) (Networking, error) {
+	fdString, ok := cfg["fd"]
+	if !ok {
+		return nil, errors.New("userspace-fd backend requires an \"fd\" option")
+	}
+	fd, err := strconv.Atoi(fdString)
+	if err != nil {
+		return nil, errors.New("userspace-fd backend: invalid fd \"" + fdString + "\"")
+	}
+	return &userspaceFDNetworking{fd: fd}, nil
+}
+
+func (n *userspaceFDNetworking) CreateBind(port uint16) (Bind, uint16, error) {
+	// os.NewFile takes ownership of the descriptor it's given: closing
+	// the *os.File (needed to release the net.PacketConn wrapper itself)
+	// closes n.fd for good, so a later BindUpdate/RebindInterface call
+	// into this same backend would be wrapping an already-closed fd.
+	// Dup it first so n.fd survives as many CreateBind calls as the
+	// device cares to make.
+	dupFd, err := syscall.Dup(n.fd)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	file := os.NewFile(uintptr(dupFd), "wireguard-bind")
+	if file == nil {
+		return nil, 0, errors.New("userspace-fd backend: invalid inherited descriptor")
+	}
+	defer file.Close()
+
+	conn, err := net.FilePacketConn(file)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if udpConn, ok := conn.(*net.UDPConn); ok {
+		if addr, ok := udpConn.LocalAddr().(*net.UDPAddr); ok {
+			port = uint16(addr.Port)
+		}
+	}
+
+	return &userspaceFDBind{conn: conn}, port, nil
+}
+
+func (n *userspaceFDNetworking) CreateBindOnAddress(addr string, port uint16) (Bind, uint16, error) {
+	// The fd already determines which local address this backend binds
+	// to, so there's nothing further to pin here; behave like CreateBind.
+	return n.CreateBind(port)
+}
+
+func (n *userspaceFDNetworking) CreateEndpoint(addr string) (Endpoint, error) {
+	dst, err := parseEndpoint(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &userspaceFDEndpoint{dst: *dst}, nil
+}
+
+/* userspaceFDBind wraps a net.PacketConn opened from an inherited fd,
+ * fulfilling the Bind interface the same way a kernel raw-socket bind
+ * does.
+ */
+type userspaceFDBind struct {
+	conn net.PacketConn
+}
+
+func (bind *userspaceFDBind) SetMark(value uint32) error {
+	// SO_MARK can't be applied to an fd we don't own the creation of;
+	// whoever opened the socket and handed us the fd is responsible for
+	// marking it beforehand.
+	return nil
+}
+
+func (bind *userspaceFDBind) receive(buff []byte) (int, Endpoint, error) {
+	n, addr, err := bind.conn.ReadFrom(buff)
+	if err != nil {
+		return 0, nil, err
+	}
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, nil, errors.New("userspace-fd backend: unexpected source address type")
+	}
+	return n, &userspaceFDEndpoint{dst: *udpAddr}, nil
+}
+
+func (bind *userspaceFDBind) ReceiveIPv4(buff []byte) (int, Endpoint, error) {
+	return bind.receive(buff)
+}
+
+func (bind *userspaceFDBind) ReceiveIPv6(buff []byte) (int, Endpoint, error) {
+	return bind.receive(buff)
+}
+
+func (bind *userspaceFDBind) Send(buff []byte, end Endpoint) error {
+	nend, ok := end.(*userspaceFDEndpoint)
+	if !ok {
+		return errors.New("userspace-fd backend: invalid endpoint type")
+	}
+	_, err := bind.conn.WriteTo(buff, &nend.dst)
+	return err
+}
+
+func (bind *userspaceFDBind) Close() error {
+	return bind.conn.Close()
+}
+
+/* userspaceFDEndpoint records the destination address of a peer. The
+ * source address is never filled in by this backend: src stays empty,
+ * so SrcIP returns nil and ClearSrc has nothing to clear.
+ */
+type userspaceFDEndpoint struct {
+	mutex  sync.Mutex
+	dst    net.UDPAddr
+	src    net.UDPAddr
+	bindID string
+}
+
+func (end *userspaceFDEndpoint) ClearSrc() {
+	end.mutex.Lock()
+	defer end.mutex.Unlock()
+	end.src = net.UDPAddr{}
+}
+
+func (end *userspaceFDEndpoint) DstToString() string {
+	return end.dst.String()
+}
+
+func (end *userspaceFDEndpoint) SrcToString() string {
+	end.mutex.Lock()
+	defer end.mutex.Unlock()
+	return end.src.String()
+}
+
+func (end *userspaceFDEndpoint) DstToBytes() []byte {
+	b := end.dst.IP.To4()
+	if b == nil {
+		b = end.dst.IP.To16()
+	}
+	return append(b, byte(end.dst.Port), byte(end.dst.Port>>8))
+}
+
+func (end *userspaceFDEndpoint) DstIP() net.IP {
+	return end.dst.IP
+}
+
+func (end *userspaceFDEndpoint) SrcIP() net.IP {
+	end.mutex.Lock()
+	defer end.mutex.Unlock()
+	return end.src.IP
+}
+
+func (end *userspaceFDEndpoint) BindID() string {
+	end.mutex.Lock()
+	defer end.mutex.Unlock()
+	return end.bindID
+}
+
+func (end *userspaceFDEndpoint) SetBindID(id string) {
+	end.mutex.Lock()
+	defer end.mutex.Unlock()
+	end.bindID = id
+}
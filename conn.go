@@ -5,6 +5,8 @@ import (
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
 	"net"
+	"sync"
+	"time"
 )
 
 /* Describes the creation of binds and endpoints on the platform
@@ -12,6 +14,52 @@ import (
 type Networking interface {
 	CreateBind(port uint16) (Bind, uint16, error)
 	CreateEndpoint(addr string) (Endpoint, error)
+
+	// CreateBindOnAddress is CreateBind pinned to a specific local
+	// address or interface name, so a multi-homed device can hold one
+	// bind per address instead of one bind for the whole host. Backends
+	// for which that distinction is meaningless (e.g. one multiplexed
+	// outer stream) are free to ignore addr and behave like CreateBind.
+	CreateBindOnAddress(addr string, port uint16) (Bind, uint16, error)
+}
+
+/* NetworkingFactory constructs a Networking backend from a set of
+ * key/value options, selected by name via the UAPI
+ * "network_backend"/"network_backend_<key>" knobs.
+ */
+type NetworkingFactory func(cfg map[string]string) (Networking, error)
+
+var (
+	networkingMutex    sync.Mutex
+	networkingBackends = make(map[string]NetworkingFactory)
+)
+
+/* RegisterNetworking makes a Networking backend available under name.
+ * Backends register themselves from an init function (see
+ * bind_userspace.go, bind_stream.go). Registering the same name twice
+ * is an error rather than silently shadowing the first registration.
+ */
+func RegisterNetworking(name string, factory NetworkingFactory) error {
+	networkingMutex.Lock()
+	defer networkingMutex.Unlock()
+	if _, ok := networkingBackends[name]; ok {
+		return errors.New("networking backend already registered: " + name)
+	}
+	networkingBackends[name] = factory
+	return nil
+}
+
+/* newNetworking looks up a backend previously registered with
+ * RegisterNetworking and constructs it with the supplied configuration.
+ */
+func newNetworking(name string, cfg map[string]string) (Networking, error) {
+	networkingMutex.Lock()
+	factory, ok := networkingBackends[name]
+	networkingMutex.Unlock()
+	if !ok {
+		return nil, errors.New("unknown networking backend: " + name)
+	}
+	return factory(cfg)
 }
 
 /* A Bind handles listening on a port for both IPv6 and IPv4 UDP traffic
@@ -37,6 +85,20 @@ type Endpoint interface {
 	DstToBytes() []byte  // used for mac2 cookie calculations
 	DstIP() net.IP
 	SrcIP() net.IP
+
+	BindID() string      // the active bind this endpoint is currently pinned to, or "" if unpinned
+	SetBindID(id string) // (re-)pins the endpoint to a specific bind; see SelectBind
+}
+
+/* StreamEndpoint is implemented by Endpoints whose traffic rides an
+ * existing outer connection instead of being addressed per-packet with
+ * sendto. A multiplexing Bind type-asserts for this to learn which
+ * connection to reuse for a reply.
+ */
+type StreamEndpoint interface {
+	Endpoint
+	Conn() net.Conn        // the underlying stream connection, or nil if none is pinned yet
+	SetConn(conn net.Conn) // pins/replaces the stream connection used for this endpoint
 }
 
 func parseEndpoint(s string) (*net.UDPAddr, error) {
@@ -60,15 +122,47 @@ func parseEndpoint(s string) (*net.UDPAddr, error) {
 	return addr, err
 }
 
+/* activeBind pairs a live Bind with the id Endpoints cache to send on
+ * it directly (see Endpoint.BindID), and the local address or interface
+ * name it's pinned to ("" for a single any-address bind).
+ */
+type activeBind struct {
+	id   string
+	addr string // local address / interface name this bind is pinned to, "" for the default bind
+	bind Bind
+	port uint16
+}
+
+/* replaceBind returns a new slice with the entry at index replaced by
+ * replacement, or removed entirely if replacement is nil. It always
+ * builds a fresh backing array rather than splicing in place, so a
+ * reader that copied the old slice header beforehand (SelectBind) never
+ * observes a half-updated one. index must be a valid index into binds.
+ */
+func replaceBind(binds []*activeBind, index int, replacement *activeBind) []*activeBind {
+	updated := make([]*activeBind, 0, len(binds))
+	for i, b := range binds {
+		switch {
+		case i != index:
+			updated = append(updated, b)
+		case replacement != nil:
+			updated = append(updated, replacement)
+		}
+	}
+	return updated
+}
+
 /* Must hold device and net lock
  */
-func unsafeCloseBind(device *Device) error {
+func unsafeCloseBinds(device *Device) error {
 	var err error
 	netc := &device.net
-	if netc.bind != nil {
-		err = netc.bind.Close()
-		netc.bind = nil
+	for _, b := range netc.binds {
+		if e := b.bind.Close(); e != nil {
+			err = e
+		}
 	}
+	netc.binds = nil
 	return err
 }
 
@@ -86,18 +180,54 @@ func (device *Device) BindSetMark(mark uint32) error {
 		return nil
 	}
 
-	// update fwmark on existing bind
+	// update fwmark on existing binds
 
 	device.net.fwmark = mark
-	if device.isUp.Get() && device.net.bind != nil {
-		if err := device.net.bind.SetMark(mark); err != nil {
-			return err
+	if device.isUp.Get() {
+		for _, b := range device.net.binds {
+			if err := b.bind.SetMark(mark); err != nil {
+				return err
+			}
 		}
 	}
 
 	return nil
 }
 
+/* createBind opens a single bind pinned to addr through the currently
+ * active backend and starts its receive routines. Caller must hold
+ * device.net.mutex. keepID preserves an existing bind's id across a
+ * rebuild (RebindInterface) instead of deriving a fresh one from addr.
+ */
+func (device *Device) createBind(addr string, keepID string) (*activeBind, error) {
+	netc := &device.net
+
+	bind, port, err := netc.network.CreateBindOnAddress(addr, netc.port)
+	if err != nil {
+		return nil, err
+	}
+
+	if netc.fwmark != 0 {
+		if err := bind.SetMark(netc.fwmark); err != nil {
+			bind.Close()
+			return nil, err
+		}
+	}
+
+	id := keepID
+	if id == "" {
+		id = addr
+		if id == "" {
+			id = "default"
+		}
+	}
+
+	go device.RoutineReceiveIncoming(ipv4.Version, bind)
+	go device.RoutineReceiveIncoming(ipv6.Version, bind)
+
+	return &activeBind{id: id, addr: addr, bind: bind, port: port}, nil
+}
+
 func (device *Device) BindUpdate() error {
 
 	device.net.mutex.Lock()
@@ -108,7 +238,7 @@ func (device *Device) BindUpdate() error {
 
 	// close existing sockets
 
-	if err := unsafeCloseBind(device); err != nil {
+	if err := unsafeCloseBinds(device); err != nil {
 		return err
 	}
 
@@ -116,50 +246,117 @@ func (device *Device) BindUpdate() error {
 
 	if device.isUp.Get() {
 
-		// bind to new port
-
-		var err error
 		netc := &device.net
-		netc.bind, netc.port, err = device.net.network.CreateBind(netc.port)
-		if err != nil {
-			netc.bind = nil
-			netc.port = 0
-			return err
+
+		// Swap in a different backend if the UAPI "network_backend"
+		// knob selected one since the last call. This is what lets a
+		// running device move from, say, the kernel UDP bind to the
+		// TCP tunnel bind in-place: the caller writes the new backend
+		// name/config over UAPI and calls BindUpdate again, rather
+		// than tearing down and recreating the device.
+		if netc.networkName != "" && netc.networkName != netc.activeNetworkName {
+			network, err := newNetworking(netc.networkName, netc.networkConfig)
+			if err != nil {
+				return err
+			}
+			netc.network = network
+			netc.activeNetworkName = netc.networkName
 		}
 
-		// set fwmark
+		// open one bind per configured local address/interface,
+		// falling back to a single any-address bind if the device has
+		// none configured over UAPI (one or more "bind_address=" lines)
+
+		addrs := netc.localAddrs
+		if len(addrs) == 0 {
+			addrs = []string{""}
+		}
 
-		if netc.fwmark != 0 {
-			err = netc.bind.SetMark(netc.fwmark)
+		for _, addr := range addrs {
+			ab, err := device.createBind(addr, "")
 			if err != nil {
+				unsafeCloseBinds(device)
+				netc.port = 0
 				return err
 			}
+			netc.binds = append(netc.binds, ab)
+			netc.port = ab.port
 		}
 
-		// clear cached source addresses
+		// clear cached source addresses and bind pins; the peer's
+		// selection policy re-picks a bind on the next packet sent
 
 		for _, peer := range device.peers.keyMap {
 			peer.mutex.Lock()
 			defer peer.mutex.Unlock()
 			if peer.endpoint != nil {
 				peer.endpoint.ClearSrc()
+				peer.endpoint.SetBindID("")
 			}
 		}
 
-		// start receiving routines
+		device.log.Debug.Println("UDP binds have been updated")
+	}
+
+	return nil
+}
+
+/* RebindInterface tears down and recreates only the bind pinned to addr
+ * (e.g. on a route/link change notification), leaving every other
+ * active bind untouched. The bind keeps its id across the rebuild, so
+ * Endpoints already pinned to it via SetBindID need no re-selection.
+ */
+func (device *Device) RebindInterface(addr string) error {
+
+	device.net.mutex.Lock()
+	defer device.net.mutex.Unlock()
+
+	netc := &device.net
+
+	index := -1
+	for i, b := range netc.binds {
+		if b.addr == addr {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return nil
+	}
+
+	old := netc.binds[index]
+	closeErr := old.bind.Close()
 
-		go device.RoutineReceiveIncoming(ipv4.Version, netc.bind)
-		go device.RoutineReceiveIncoming(ipv6.Version, netc.bind)
+	ab, err := device.createBind(addr, old.id)
 
-		device.log.Debug.Println("UDP bind has been updated")
+	// see replaceBind's doc comment for why this isn't an in-place
+	// splice of netc.binds
+	var replacement *activeBind
+	if err == nil {
+		replacement = ab
 	}
+	netc.binds = replaceBind(netc.binds, index, replacement)
 
+	if err != nil {
+		// The old bind is unusable either way (we just tried to close
+		// it), so it's simply dropped above rather than left as a dead
+		// entry that SelectBind could still hand out to a peer.
+		if closeErr != nil {
+			return closeErr
+		}
+		return err
+	}
+
+	if closeErr != nil {
+		device.log.Debug.Println("error closing old bind for interface", addr, ":", closeErr)
+	}
+	device.log.Debug.Println("rebuilt bind for interface", addr, "after route event")
 	return nil
 }
 
 func (device *Device) BindClose() error {
 	device.net.mutex.Lock()
-	err := unsafeCloseBind(device)
+	err := unsafeCloseBinds(device)
 	device.net.mutex.Unlock()
 	return err
 }
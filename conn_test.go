@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestReplaceBindReplacesAtSameIndex(t *testing.T) {
+	a := &activeBind{id: "a"}
+	b := &activeBind{id: "b"}
+	c := &activeBind{id: "c"}
+	original := []*activeBind{a, b, c}
+
+	replacementB := &activeBind{id: "b2"}
+	updated := replaceBind(original, 1, replacementB)
+
+	if len(updated) != 3 || updated[0] != a || updated[1] != replacementB || updated[2] != c {
+		t.Fatalf("unexpected result: %+v", updated)
+	}
+
+	// The original slice must be untouched: a concurrent reader (like
+	// SelectBind) that copied the slice header before this call has to
+	// keep seeing the old contents, which is only true if replaceBind
+	// never writes into the original backing array.
+	if original[0] != a || original[1] != b || original[2] != c {
+		t.Fatalf("replaceBind mutated the original backing array: %+v", original)
+	}
+}
+
+func TestReplaceBindRemovesOnNilReplacement(t *testing.T) {
+	a := &activeBind{id: "a"}
+	b := &activeBind{id: "b"}
+	original := []*activeBind{a, b}
+
+	updated := replaceBind(original, 0, nil)
+
+	if len(updated) != 1 || updated[0] != b {
+		t.Fatalf("unexpected result: %+v", updated)
+	}
+	if original[0] != a || original[1] != b {
+		t.Fatalf("replaceBind mutated the original backing array: %+v", original)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import "errors"
+
+/* SendBuffer transmits a raw WireGuard packet to the peer's current
+ * endpoint. The bind it goes out on is whatever SelectBind picks for
+ * this peer (its pinned bind if one is still alive, otherwise a fresh
+ * choice from its BindPolicy), not a single device-wide bind.
+ */
+func (peer *Peer) SendBuffer(buffer []byte) error {
+
+	peer.mutex.Lock()
+	endpoint := peer.endpoint
+	peer.mutex.Unlock()
+
+	if endpoint == nil {
+		return errors.New("no known endpoint for peer")
+	}
+
+	bind, err := peer.device.SelectBind(peer, endpoint)
+	if err != nil {
+		return err
+	}
+
+	return bind.Send(buffer, endpoint)
+}